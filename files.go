@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxInputFileBytes caps a single input_files entry after base64 decoding.
+	maxInputFileBytes = 10 << 20 // 10 MiB
+	// maxInputTotalBytes caps the combined size of all input_files entries.
+	maxInputTotalBytes = 25 << 20 // 25 MiB
+	// maxOutputFileBytes caps a single harvested output file.
+	maxOutputFileBytes = 10 << 20 // 10 MiB
+	// maxOutputTotalBytes caps the combined size of all harvested output files.
+	maxOutputTotalBytes = 25 << 20 // 25 MiB
+)
+
+// harvestedFile is one file read back from the sandbox after execution, ready
+// to attach to the CallToolResult as a blob resource.
+type harvestedFile struct {
+	path     string
+	mimeType string
+	base64   string
+}
+
+// writeInputFiles decodes inputFiles (relative path -> base64 content) and
+// writes each one under workDir, so the execution (docker mount or host
+// tmpDir) can see it at start. Paths are confined to workDir to rule out
+// `../` escaping it.
+func writeInputFiles(workDir string, inputFiles map[string]string) error {
+	total := 0
+	for relPath, encoded := range inputFiles {
+		cleanPath, err := safeJoin(workDir, relPath)
+		if err != nil {
+			return fmt.Errorf("input_files[%q]: %w", relPath, err)
+		}
+
+		content, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("input_files[%q]: invalid base64: %w", relPath, err)
+		}
+		if len(content) > maxInputFileBytes {
+			return fmt.Errorf(
+				"input_files[%q]: %d bytes exceeds per-file limit of %d",
+				relPath, len(content), maxInputFileBytes,
+			)
+		}
+		total += len(content)
+		if total > maxInputTotalBytes {
+			return fmt.Errorf(
+				"input_files: combined size exceeds limit of %d bytes", maxInputTotalBytes,
+			)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(cleanPath), 0755); err != nil {
+			return fmt.Errorf("input_files[%q]: failed to create parent dir: %w", relPath, err)
+		}
+		if err := os.WriteFile(cleanPath, content, 0644); err != nil {
+			return fmt.Errorf("input_files[%q]: failed to write file: %w", relPath, err)
+		}
+	}
+	return nil
+}
+
+// harvestOutputFiles reads back every file under workDir matching any of
+// globs, base64-encoding each for return to the caller. Total and per-file
+// size caps protect against a script producing huge artifacts.
+func harvestOutputFiles(workDir string, globs []string) ([]harvestedFile, error) {
+	var files []harvestedFile
+	seen := make(map[string]bool)
+	total := 0
+
+	for _, pattern := range globs {
+		cleanPattern, err := safeJoin(workDir, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("output_globs[%q]: %w", pattern, err)
+		}
+
+		matches, err := filepath.Glob(cleanPattern)
+		if err != nil {
+			return nil, fmt.Errorf("output_globs[%q]: %w", pattern, err)
+		}
+
+		for _, match := range matches {
+			// Lstat, not Stat: a match that is itself a symlink must be
+			// rejected before anything follows it, since a script can plant
+			// one (e.g. os.symlink("/etc/passwd", "leak.txt")) to read files
+			// outside workDir straight off the host running the server.
+			info, err := os.Lstat(match)
+			if err != nil || seen[match] {
+				continue
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				return nil, fmt.Errorf("output file %q is a symlink, which is not allowed", match)
+			}
+			if info.IsDir() {
+				continue
+			}
+			seen[match] = true
+
+			if info.Size() > maxOutputFileBytes {
+				return nil, fmt.Errorf(
+					"output file %q is %d bytes, exceeds per-file limit of %d",
+					match, info.Size(), maxOutputFileBytes,
+				)
+			}
+			total += int(info.Size())
+			if total > maxOutputTotalBytes {
+				return nil, fmt.Errorf(
+					"output_globs: combined size exceeds limit of %d bytes", maxOutputTotalBytes,
+				)
+			}
+
+			content, err := os.ReadFile(match)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read output file %q: %w", match, err)
+			}
+
+			relPath, err := filepath.Rel(workDir, match)
+			if err != nil {
+				relPath = match
+			}
+
+			files = append(files, harvestedFile{
+				path:     relPath,
+				mimeType: mimeTypeForPath(match),
+				base64:   base64.StdEncoding.EncodeToString(content),
+			})
+		}
+	}
+
+	return files, nil
+}
+
+// safeJoin joins workDir with relPath, rejecting anything that would escape
+// workDir via `..` segments. An absolute relPath is not rejected: it is
+// nested under workDir like any other path. Symlinks are not resolved.
+func safeJoin(workDir, relPath string) (string, error) {
+	joined := filepath.Join(workDir, relPath)
+	cleanWorkDir := filepath.Clean(workDir)
+	if joined != cleanWorkDir && !strings.HasPrefix(joined, cleanWorkDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("path escapes the execution directory")
+	}
+	return joined, nil
+}
+
+func mimeTypeForPath(path string) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// parseInputFiles decodes the execute-python `input_files` argument, a JSON
+// object mapping relative path to base64 content.
+func parseInputFiles(raw string) (map[string]string, error) {
+	if raw == "" {
+		return nil, nil
+	}
+	var files map[string]string
+	if err := json.Unmarshal([]byte(raw), &files); err != nil {
+		return nil, fmt.Errorf("must be a JSON object of path to base64 content: %w", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	workDir := "/tmp/mcp-python-workdir"
+
+	tests := []struct {
+		name    string
+		relPath string
+		wantErr bool
+	}{
+		{name: "plain relative path", relPath: "out.txt", wantErr: false},
+		{name: "nested relative path", relPath: "sub/dir/out.txt", wantErr: false},
+		{name: "current dir", relPath: ".", wantErr: false},
+		{name: "parent dir escape", relPath: "../escaped.txt", wantErr: true},
+		{name: "nested parent dir escape", relPath: "sub/../../escaped.txt", wantErr: true},
+		// filepath.Join treats a leading "/" as just another path segment, so
+		// these stay nested under workDir rather than escaping it.
+		{name: "absolute path is nested, not escaped", relPath: "/etc/passwd", wantErr: false},
+		{name: "absolute path matching workdir prefix is nested, not escaped", relPath: "/tmp/mcp-python-workdir-evil/out.txt", wantErr: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := safeJoin(workDir, tc.relPath)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("safeJoin(%q, %q) = %q, want error", workDir, tc.relPath, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("safeJoin(%q, %q) returned unexpected error: %v", workDir, tc.relPath, err)
+			}
+			if !strings.HasPrefix(got, filepath.Clean(workDir)) {
+				t.Fatalf("safeJoin(%q, %q) = %q, want path under workDir", workDir, tc.relPath, got)
+			}
+		})
+	}
+}
+
+func TestWriteInputFilesRejectsEscape(t *testing.T) {
+	workDir := t.TempDir()
+
+	err := writeInputFiles(workDir, map[string]string{
+		"../escaped.txt": "aGk=", // "hi"
+	})
+	if err == nil {
+		t.Fatal("writeInputFiles with a path-escaping key should have failed")
+	}
+}
+
+func TestWriteInputFilesEnforcesPerFileCap(t *testing.T) {
+	workDir := t.TempDir()
+
+	oversized := make([]byte, maxInputFileBytes+1)
+	encoded := base64.StdEncoding.EncodeToString(oversized)
+
+	err := writeInputFiles(workDir, map[string]string{"big.bin": encoded})
+	if err == nil {
+		t.Fatal("writeInputFiles should reject a single file over maxInputFileBytes")
+	}
+}
+
+func TestWriteInputFilesEnforcesTotalCap(t *testing.T) {
+	workDir := t.TempDir()
+
+	// Three files, each under the per-file cap, whose combined size exceeds
+	// maxInputTotalBytes.
+	third := make([]byte, maxInputTotalBytes/3+1)
+	encoded := base64.StdEncoding.EncodeToString(third)
+
+	err := writeInputFiles(workDir, map[string]string{
+		"a.bin": encoded,
+		"b.bin": encoded,
+		"c.bin": encoded,
+	})
+	if err == nil {
+		t.Fatal("writeInputFiles should reject input_files whose combined size exceeds maxInputTotalBytes")
+	}
+}
+
+func TestHarvestOutputFilesRejectsEscape(t *testing.T) {
+	workDir := t.TempDir()
+
+	_, err := harvestOutputFiles(workDir, []string{"../*"})
+	if err == nil {
+		t.Fatal("harvestOutputFiles with a path-escaping glob should have failed")
+	}
+}
+
+func TestHarvestOutputFilesEnforcesPerFileCap(t *testing.T) {
+	workDir := t.TempDir()
+
+	if err := os.WriteFile(
+		filepath.Join(workDir, "big.bin"),
+		make([]byte, maxOutputFileBytes+1),
+		0644,
+	); err != nil {
+		t.Fatalf("failed to seed output file: %v", err)
+	}
+
+	_, err := harvestOutputFiles(workDir, []string{"*.bin"})
+	if err == nil {
+		t.Fatal("harvestOutputFiles should reject a single file over maxOutputFileBytes")
+	}
+}
+
+func TestHarvestOutputFilesRejectsSymlinkEscape(t *testing.T) {
+	workDir := t.TempDir()
+
+	outsideDir := t.TempDir()
+	secret := filepath.Join(outsideDir, "secret.txt")
+	if err := os.WriteFile(secret, []byte("outside workDir"), 0644); err != nil {
+		t.Fatalf("failed to seed secret file: %v", err)
+	}
+
+	if err := os.Symlink(secret, filepath.Join(workDir, "leak.txt")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	_, err := harvestOutputFiles(workDir, []string{"leak.txt"})
+	if err == nil {
+		t.Fatal("harvestOutputFiles should reject a match that is a symlink")
+	}
+}
+
+func TestHarvestOutputFilesRoundTrip(t *testing.T) {
+	workDir := t.TempDir()
+	want := []byte("hello from the sandbox")
+
+	if err := os.WriteFile(filepath.Join(workDir, "out.txt"), want, 0644); err != nil {
+		t.Fatalf("failed to seed output file: %v", err)
+	}
+
+	files, err := harvestOutputFiles(workDir, []string{"*.txt"})
+	if err != nil {
+		t.Fatalf("harvestOutputFiles returned unexpected error: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("harvestOutputFiles returned %d files, want 1", len(files))
+	}
+	if files[0].path != "out.txt" {
+		t.Fatalf("harvested file path = %q, want %q", files[0].path, "out.txt")
+	}
+}
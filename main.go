@@ -1,24 +1,58 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
-	"os"
-	"os/exec"
-	"path"
 	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/mark3labs/mcp-go/mcp"
 	"github.com/mark3labs/mcp-go/server"
 )
 
+const (
+	// defaultTimeoutSeconds is used when a caller omits timeout_seconds.
+	defaultTimeoutSeconds = 60
+	// defaultMaxOutputBytes caps how much of stdout/stderr we retain per stream.
+	defaultMaxOutputBytes = 1 << 20 // 1 MiB
+)
+
 func main() {
 	// Parse command line flags
 	sseMode := flag.Bool("sse", false, "Run in SSE mode instead of stdio mode")
+	maxTimeoutSeconds := flag.Int(
+		"max-timeout-seconds",
+		300,
+		"Upper bound on the timeout_seconds a caller may request for execute-python",
+	)
+	maxOutputBytes := flag.Int(
+		"max-output-bytes",
+		defaultMaxOutputBytes,
+		"Maximum bytes of stdout or stderr retained per execution",
+	)
+	runnerName := flag.String(
+		"runner",
+		"docker",
+		"Execution backend for execute-python: docker, venv, or gvisor",
+	)
+	allowHostRunner := flag.Bool(
+		"allow-host-runner",
+		false,
+		"Allow execute-python-workflow steps to request runner: host, executing shell directly on this machine with its full environment. Leave disabled unless callers are trusted.",
+	)
 	flag.Parse()
 
+	runner, err := newRunner(*runnerName)
+	if err != nil {
+		log.Fatalf("Invalid --runner: %v", err)
+	}
+
 	// Create MCP server with basic capabilities
 	mcpServer := server.NewMCPServer(
 		"python-executor",
@@ -42,14 +76,95 @@ func main() {
 				"Comma-separated list of Python modules your code requires. If your code requires external modules you MUST pass them here! These will installed automatically.",
 			),
 		),
+		mcp.WithNumber(
+			"timeout_seconds",
+			mcp.Description(
+				fmt.Sprintf(
+					"How long to let the script run before it is killed. Defaults to %d seconds and is capped by the server's --max-timeout-seconds flag.",
+					defaultTimeoutSeconds,
+				),
+			),
+		),
+		mcp.WithString(
+			"input_files",
+			mcp.Description(
+				"JSON object mapping a relative file path to its base64-encoded content. Each file is written before the script runs, so it can read screenshots, datasets, etc. from disk instead of being passed through print().",
+			),
+		),
+		mcp.WithString(
+			"output_globs",
+			mcp.Description(
+				"Comma-separated list of glob patterns (relative to the script's working directory) whose matches are read back after execution and returned as base64 blobs. Use this to harvest screenshots, PDFs, or other files Playwright writes to disk.",
+			),
+		),
+	)
+
+	mcpServer.AddTool(
+		pythonTool,
+		handlePythonExecution(mcpServer, runner, *maxTimeoutSeconds, *maxOutputBytes),
+	)
+
+	// A persistent REPL per MCP session, so variables/imports survive across
+	// execute-python-session calls the way they would in a Jupyter kernel.
+	sessions := newSessionManager(defaultIdleTimeout, *maxOutputBytes)
+
+	sessionTool := mcp.NewTool(
+		"execute-python-session",
+		mcp.WithDescription(
+			"Execute Python code in a persistent, stateful interpreter. Unlike execute-python, variables, imports and installed modules persist across calls that share the same session_id. Omit session_id on the first call and reuse the one returned in the result for subsequent calls.",
+		),
+		mcp.WithString(
+			"code",
+			mcp.Description("The Python code to execute in the session"),
+			mcp.Required(),
+		),
+		mcp.WithString(
+			"session_id",
+			mcp.Description(
+				"The session to run in. Omit to start a new session; the generated id is returned in the result so you can reuse it.",
+			),
+		),
+		mcp.WithString(
+			"modules",
+			mcp.Description(
+				"Comma-separated list of Python modules to pip install into the session before running code. Only needs to be passed once per module per session.",
+			),
+		),
 	)
+	mcpServer.AddTool(sessionTool, handlePythonSessionExecution(sessions))
 
-	mcpServer.AddTool(pythonTool, handlePythonExecution)
+	resetTool := mcp.NewTool(
+		"reset-python-session",
+		mcp.WithDescription(
+			"Tear down a session container started by execute-python-session, discarding its state.",
+		),
+		mcp.WithString(
+			"session_id",
+			mcp.Description("The session to reset"),
+			mcp.Required(),
+		),
+	)
+	mcpServer.AddTool(resetTool, handleResetPythonSession(sessions))
+
+	workflowTool := mcp.NewTool(
+		"execute-python-workflow",
+		mcp.WithDescription(
+			"Run an ordered list of Python steps described as a YAML or JSON document, each with its own optional before/after shell hooks, modules, env, workdir, timeout, and runner (\"host\" or \"container\"). Useful for multi-stage scenarios (e.g. login, navigate, extract) that shouldn't be crammed into one execute-python call. Stops at the first step that fails or whose expect_stdout_regex doesn't match, returning the results gathered so far.",
+		),
+		mcp.WithString(
+			"workflow",
+			mcp.Description(
+				"YAML or JSON document with a top-level `steps` list. Each step supports: code (required), modules, before, after, expect_stdout_regex, runner (host|container), env, workdir, timeout_seconds.",
+			),
+			mcp.Required(),
+		),
+	)
+	mcpServer.AddTool(workflowTool, handlePythonWorkflow(*maxTimeoutSeconds, *maxOutputBytes, *allowHostRunner))
 
 	// Run server in appropriate mode
 	if *sseMode {
 		// Create and start SSE server
-		sseServer := server.NewSSEServer(mcpServer, "http://localhost:8080")
+		sseServer := server.NewSSEServer(mcpServer, server.WithBaseURL("http://localhost:8080"))
 		log.Printf("Starting SSE server on localhost:8080")
 		if err := sseServer.Start(":8080"); err != nil {
 			log.Fatalf("Server error: %v", err)
@@ -62,72 +177,290 @@ func main() {
 	}
 }
 
-// handlePythonExecution handles the execute-python tool calls
-func handlePythonExecution(
-	ctx context.Context,
-	request mcp.CallToolRequest,
-) (*mcp.CallToolResult, error) {
-	code, ok := request.Params.Arguments["code"].(string)
-	if !ok {
-		return mcp.NewToolResultError("Missing or invalid code argument"), nil
-	}
+// executionResult is the structured payload returned to the caller as the
+// tool's text content, so both streams, the exit code and timeout status
+// survive the trip through a single CallToolResult.
+type executionResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+	TimedOut bool   `json:"timed_out"`
+}
 
-	// Handle optional modules argument
-	var modules []string
-	if modulesStr, ok := request.Params.Arguments["modules"].(string); ok &&
-		modulesStr != "" {
-		modules = strings.Split(modulesStr, ",")
-	}
+// limitedBuffer is an io.Writer that retains at most max bytes, recording how
+// much it had to discard so runaway prints can't OOM the server.
+type limitedBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
 
-	tmpDir, err := os.MkdirTemp("", "python_repl")
-	if err != nil {
-		return mcp.NewToolResultError(
-			fmt.Sprintf("Failed to create temp dir: %v", err),
-		), nil
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		b.buf.Write(p[:room])
+		b.dropped += len(p) - room
+	} else {
+		b.dropped += len(p)
 	}
-	defer os.RemoveAll(tmpDir)
+	return len(p), nil
+}
 
-	err = os.WriteFile(path.Join(tmpDir, "script.py"), []byte(code), 0644)
-	if err != nil {
-		return mcp.NewToolResultError(
-			fmt.Sprintf("Failed to write script to file: %v", err),
-		), nil
+func (b *limitedBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
 	}
+	return fmt.Sprintf("%s\n... [truncated, %d bytes dropped]", b.buf.String(), b.dropped)
+}
 
-	cmdArgs := []string{
-		"run",
-		"--rm",
-		"-v",
-		fmt.Sprintf("%s:/app", tmpDir),
-		"mcr.microsoft.com/playwright/python:v1.49.1-noble",
+// handlePythonExecution returns the execute-python tool handler, closing over
+// the Runner backend and the server-wide limits an individual call may not
+// exceed.
+func handlePythonExecution(
+	mcpServer *server.MCPServer,
+	runner Runner,
+	maxTimeoutSeconds int,
+	maxOutputBytes int,
+) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		code, ok := request.Params.Arguments["code"].(string)
+		if !ok {
+			return mcp.NewToolResultError("Missing or invalid code argument"), nil
+		}
+
+		// Handle optional modules argument
+		var modules []string
+		if modulesStr, ok := request.Params.Arguments["modules"].(string); ok &&
+			modulesStr != "" {
+			modules = strings.Split(modulesStr, ",")
+		}
+
+		inputFilesStr, _ := request.Params.Arguments["input_files"].(string)
+		inputFiles, err := parseInputFiles(inputFilesStr)
+		if err != nil {
+			return mcp.NewToolResultError(
+				fmt.Sprintf("Invalid input_files argument: %v", err),
+			), nil
+		}
+
+		var outputGlobs []string
+		if globsStr, ok := request.Params.Arguments["output_globs"].(string); ok &&
+			globsStr != "" {
+			outputGlobs = strings.Split(globsStr, ",")
+		}
+
+		timeoutSeconds := defaultTimeoutSeconds
+		if v, ok := request.Params.Arguments["timeout_seconds"].(float64); ok && v > 0 {
+			timeoutSeconds = int(v)
+		}
+		if timeoutSeconds > maxTimeoutSeconds {
+			timeoutSeconds = maxTimeoutSeconds
+		}
+
+		execCtx, cancel := context.WithTimeout(
+			ctx,
+			time.Duration(timeoutSeconds)*time.Second,
+		)
+		defer cancel()
+
+		handle, err := runner.Prepare(execCtx, code, modules)
+		if err != nil {
+			return mcp.NewToolResultError(
+				runnerErrorMessage("Setup failed", err),
+			), nil
+		}
+		defer runner.Cleanup(handle)
+
+		if len(inputFiles) > 0 {
+			if err := writeInputFiles(runner.Workdir(handle), inputFiles); err != nil {
+				return mcp.NewToolResultError(
+					fmt.Sprintf("Failed to stage input_files: %v", err),
+				), nil
+			}
+		}
+
+		var onOutput OutputLineFunc
+		var progress int64
+		var progressToken mcp.ProgressToken
+		if request.Params.Meta != nil && request.Params.Meta.ProgressToken != nil {
+			progressToken = request.Params.Meta.ProgressToken
+			onOutput = func(stream, line string) {
+				n := atomic.AddInt64(&progress, 1)
+				_ = mcpServer.SendNotificationToClient(ctx, "notifications/progress", map[string]interface{}{
+					"progressToken": progressToken,
+					"progress":      float64(n),
+					"stream":        stream,
+					"line":          line,
+				})
+			}
+		}
+
+		runResult, runErr := runner.Run(execCtx, handle, maxOutputBytes, onOutput)
+		timedOut := execCtx.Err() == context.DeadlineExceeded
+		if progressToken != nil {
+			n := atomic.AddInt64(&progress, 1)
+			_ = mcpServer.SendNotificationToClient(ctx, "notifications/progress", map[string]interface{}{
+				"progressToken": progressToken,
+				"progress":      float64(n),
+				"exit_code":     runResult.ExitCode,
+				"timed_out":     timedOut,
+			})
+		}
+		if runErr != nil && !timedOut {
+			return mcp.NewToolResultError(
+				runnerErrorMessage("Execution failed", runErr),
+			), nil
+		}
+
+		var harvested []harvestedFile
+		if len(outputGlobs) > 0 {
+			harvested, err = harvestOutputFiles(runner.Workdir(handle), outputGlobs)
+			if err != nil {
+				return mcp.NewToolResultError(
+					fmt.Sprintf("Failed to harvest output_globs: %v", err),
+				), nil
+			}
+		}
+
+		result := executionResult{
+			Stdout:   runResult.Stdout,
+			Stderr:   runResult.Stderr,
+			ExitCode: runResult.ExitCode,
+			TimedOut: timedOut,
+		}
+
+		payload, err := json.Marshal(result)
+		if err != nil {
+			return mcp.NewToolResultError(
+				fmt.Sprintf("Failed to encode result: %v", err),
+			), nil
+		}
+
+		content := []mcp.Content{
+			mcp.TextContent{Type: "text", Text: string(payload)},
+		}
+		for _, f := range harvested {
+			content = append(content, mcp.EmbeddedResource{
+				Type: "resource",
+				Resource: mcp.BlobResourceContents{
+					URI:      "file://" + f.path,
+					MIMEType: f.mimeType,
+					Blob:     f.base64,
+				},
+			})
+		}
+
+		return &mcp.CallToolResult{Content: content}, nil
 	}
-	shArgs := []string{}
+}
+
+// sessionExecutionResult is the structured payload returned by
+// execute-python-session; session_id is always populated so a caller that
+// omitted it on the first call can thread it through to later ones.
+type sessionExecutionResult struct {
+	SessionID string `json:"session_id"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+}
+
+// handlePythonSessionExecution returns the execute-python-session tool
+// handler, closing over the sessionManager that owns the running containers.
+func handlePythonSessionExecution(sessions *sessionManager) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		code, ok := request.Params.Arguments["code"].(string)
+		if !ok {
+			return mcp.NewToolResultError("Missing or invalid code argument"), nil
+		}
 
-	if len(modules) > 0 {
-		shArgs = append(shArgs, "python", "-m", "pip", "install", "--quiet")
-		shArgs = append(shArgs, modules...)
-		shArgs = append(shArgs, "&&")
+		sessionID, _ := request.Params.Arguments["session_id"].(string)
+		if sessionID == "" {
+			sessionID = uuid.NewString()
+		}
+
+		var modules []string
+		if modulesStr, ok := request.Params.Arguments["modules"].(string); ok &&
+			modulesStr != "" {
+			modules = strings.Split(modulesStr, ",")
+		}
+
+		sess, err := sessions.getOrCreate(sessionID)
+		if err != nil {
+			return mcp.NewToolResultError(
+				fmt.Sprintf("Failed to start session: %v", err),
+			), nil
+		}
+
+		if len(modules) > 0 {
+			installCode := fmt.Sprintf(
+				"import subprocess, sys\nsubprocess.check_call([sys.executable, '-m', 'pip', 'install', '--quiet', %s])\n",
+				quotedPyList(modules),
+			)
+			if _, stderr, err := sess.execute(ctx, installCode); err != nil {
+				return mcp.NewToolResultError(
+					fmt.Sprintf("Failed to install modules: %v\n%s", err, stderr),
+				), nil
+			}
+		}
+
+		stdout, stderr, err := sess.execute(ctx, code)
+		if err != nil {
+			return mcp.NewToolResultError(
+				fmt.Sprintf("Execution failed: %v", err),
+			), nil
+		}
+
+		payload, err := json.Marshal(sessionExecutionResult{
+			SessionID: sessionID,
+			Stdout:    stdout,
+			Stderr:    stderr,
+		})
+		if err != nil {
+			return mcp.NewToolResultError(
+				fmt.Sprintf("Failed to encode result: %v", err),
+			), nil
+		}
+
+		return mcp.NewToolResultText(string(payload)), nil
 	}
+}
 
-	shArgs = append(shArgs, "python", path.Join("app", "script.py"))
-	cmdArgs = append(cmdArgs, "sh", "-c", strings.Join(shArgs, " "))
+// handleResetPythonSession returns the reset-python-session tool handler.
+func handleResetPythonSession(sessions *sessionManager) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		sessionID, ok := request.Params.Arguments["session_id"].(string)
+		if !ok || sessionID == "" {
+			return mcp.NewToolResultError("Missing or invalid session_id argument"), nil
+		}
 
-	cmd := exec.Command("docker", cmdArgs...)
-	out, err := cmd.Output()
-	if err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
+		if !sessions.reset(sessionID) {
 			return mcp.NewToolResultError(
-				fmt.Sprintf(
-					"Python exited with code %d: %s",
-					exitError.ExitCode(),
-					string(exitError.Stderr),
-				),
+				fmt.Sprintf("No session found for session_id %q", sessionID),
 			), nil
 		}
-		return mcp.NewToolResultError(
-			fmt.Sprintf("Execution failed: %v", err),
+
+		return mcp.NewToolResultText(
+			fmt.Sprintf("Session %s reset", sessionID),
 		), nil
 	}
+}
 
-	return mcp.NewToolResultText(string(out)), nil
+// quotedPyList renders modules as a comma-separated list of Python string
+// literals suitable for splicing into a pip install argv list.
+func quotedPyList(modules []string) string {
+	quoted := make([]string, len(modules))
+	for i, m := range modules {
+		quoted[i] = fmt.Sprintf("%q", strings.TrimSpace(m))
+	}
+	return strings.Join(quoted, ", ")
 }
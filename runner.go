@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// defaultPlaywrightImage is the image the docker-backed runners launch.
+const defaultPlaywrightImage = "mcr.microsoft.com/playwright/python:v1.49.1-noble"
+
+// RunHandle is an opaque reference a Runner hands back from Prepare and
+// expects back unchanged in Run and Cleanup. Its concrete type is private to
+// each Runner implementation.
+type RunHandle interface{}
+
+// RunResult is what a Runner produces after executing prepared code.
+type RunResult struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// RunnerError distinguishes a failure that happened while staging the
+// environment (e.g. a `pip install`) from one that happened while running the
+// caller's script, so handlers can report more than just "something failed".
+type RunnerError struct {
+	Stage string // "install" or "run"
+	Err   error
+}
+
+func (e *RunnerError) Error() string {
+	return fmt.Sprintf("%s failed: %v", e.Stage, e.Err)
+}
+
+func (e *RunnerError) Unwrap() error { return e.Err }
+
+// OutputLineFunc is called once per line of stdout or stderr as a script
+// runs, tagged with which stream it came from. Pass nil to Run when no live
+// streaming is needed; the final buffered output is always returned either
+// way.
+type OutputLineFunc func(stream, line string)
+
+// Runner executes Python code in some sandboxed environment. Implementations
+// are selected at startup via the --runner flag.
+type Runner interface {
+	// Prepare stages code and installs modules, returning a handle Run can
+	// execute and Cleanup must eventually release. A failed install is
+	// reported as a *RunnerError with Stage "install".
+	Prepare(ctx context.Context, code string, modules []string) (RunHandle, error)
+	// Run executes the prepared handle, honoring ctx's deadline and invoking
+	// onOutput as each line of output arrives. A failed script is reported as
+	// a *RunnerError with Stage "run".
+	Run(ctx context.Context, handle RunHandle, maxOutputBytes int, onOutput OutputLineFunc) (RunResult, error)
+	// Cleanup releases any resources Prepare or Run allocated.
+	Cleanup(handle RunHandle)
+	// Workdir returns the host directory the script sees as its working
+	// directory (the docker mount source, or the venv's own tmpDir), so
+	// callers can seed input files before Run and harvest output files after.
+	Workdir(handle RunHandle) string
+}
+
+// newRunner constructs the Runner selected by the --runner flag.
+func newRunner(name string) (Runner, error) {
+	switch name {
+	case "", "docker":
+		return &dockerRunner{image: defaultPlaywrightImage}, nil
+	case "venv":
+		return &localVenvRunner{}, nil
+	case "gvisor":
+		return &dockerRunner{image: defaultPlaywrightImage, extraArgs: []string{"--runtime", "runsc"}}, nil
+	default:
+		return nil, fmt.Errorf("unknown runner %q (want docker, venv, or gvisor)", name)
+	}
+}
+
+// runStreamed starts cmd and drains its stdout/stderr pipes concurrently,
+// capturing each into a size-capped buffer and, if onOutput is non-nil,
+// handing every complete line to it as it arrives rather than waiting for
+// the process to exit. This is what lets a long scraping job report progress
+// instead of appearing frozen until it finishes.
+func runStreamed(cmd *exec.Cmd, maxOutputBytes int, onOutput OutputLineFunc) (RunResult, error) {
+	stdout := limitedBuffer{max: maxOutputBytes}
+	stderr := limitedBuffer{max: maxOutputBytes}
+
+	stdoutPipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return RunResult{}, fmt.Errorf("failed to open stdout pipe: %w", err)
+	}
+	stderrPipe, err := cmd.StderrPipe()
+	if err != nil {
+		return RunResult{}, fmt.Errorf("failed to open stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return RunResult{}, err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go drainStream(&wg, stdoutPipe, &stdout, "stdout", onOutput)
+	go drainStream(&wg, stderrPipe, &stderr, "stderr", onOutput)
+	wg.Wait()
+
+	runErr := cmd.Wait()
+	exitCode := 0
+	if runErr != nil {
+		if exitError, ok := runErr.(*exec.ExitError); ok {
+			exitCode = exitError.ExitCode()
+		} else {
+			return RunResult{}, runErr
+		}
+	}
+
+	result := RunResult{Stdout: stdout.String(), Stderr: stderr.String(), ExitCode: exitCode}
+	if exitCode != 0 {
+		return result, fmt.Errorf("exited with code %d: %s", exitCode, stderr.String())
+	}
+	return result, nil
+}
+
+// drainStream copies r line by line into buf, additionally calling onOutput
+// (if set) with each line tagged by stream. Both stdout and stderr must be
+// drained concurrently like this or a process that fills one pipe's buffer
+// while nothing reads it will deadlock.
+func drainStream(wg *sync.WaitGroup, r io.Reader, buf *limitedBuffer, stream string, onOutput OutputLineFunc) {
+	defer wg.Done()
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		buf.Write([]byte(line + "\n"))
+		if onOutput != nil {
+			onOutput(stream, line)
+		}
+	}
+}
+
+// dockerHandle is the RunHandle produced by dockerRunner.
+type dockerHandle struct {
+	tmpDir        string
+	containerName string
+	depsDir       string
+	hasDeps       bool
+}
+
+// dockerRunner is the original docker-based backend, generalized so the same
+// code also drives the gvisor (runsc) variant via extraArgs.
+type dockerRunner struct {
+	image     string
+	extraArgs []string // e.g. ["--runtime", "runsc"] for the gvisor variant
+}
+
+func (r *dockerRunner) Prepare(ctx context.Context, code string, modules []string) (RunHandle, error) {
+	tmpDir, err := os.MkdirTemp("", "python_repl")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+
+	if err := os.WriteFile(path.Join(tmpDir, "script.py"), []byte(code), 0644); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("failed to write script to file: %w", err)
+	}
+
+	handle := &dockerHandle{
+		tmpDir:        tmpDir,
+		containerName: fmt.Sprintf("mcp-python-%s", uuid.NewString()),
+	}
+
+	if len(modules) > 0 {
+		depsDir := path.Join(tmpDir, ".deps")
+		if err := os.Mkdir(depsDir, 0755); err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, fmt.Errorf("failed to create deps dir: %w", err)
+		}
+
+		installContainerName := handle.containerName + "-install"
+		installArgs := append([]string{"run", "--rm", "--name", installContainerName}, r.extraArgs...)
+		installArgs = append(installArgs,
+			"-v", fmt.Sprintf("%s:/app", tmpDir),
+			r.image,
+			"python", "-m", "pip", "install", "--quiet", "--target", "/app/.deps",
+		)
+		installArgs = append(installArgs, modules...)
+
+		cmd := exec.CommandContext(ctx, "docker", installArgs...)
+		if _, err := runStreamed(cmd, defaultMaxOutputBytes, nil); err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				// As in Run, exec.CommandContext only kills the local `docker
+				// run` client, not the container it started, so reap it by
+				// name too or it leaks on the daemon forever.
+				_ = exec.Command("docker", "kill", installContainerName).Run()
+			}
+			os.RemoveAll(tmpDir)
+			return nil, &RunnerError{Stage: "install", Err: err}
+		}
+		handle.depsDir = depsDir
+		handle.hasDeps = true
+	}
+
+	return handle, nil
+}
+
+func (r *dockerRunner) Run(ctx context.Context, h RunHandle, maxOutputBytes int, onOutput OutputLineFunc) (RunResult, error) {
+	handle := h.(*dockerHandle)
+
+	runArgs := append([]string{"run", "--rm", "--name", handle.containerName}, r.extraArgs...)
+	runArgs = append(runArgs, "-v", fmt.Sprintf("%s:/app", handle.tmpDir), "-w", "/app")
+	if handle.hasDeps {
+		runArgs = append(runArgs, "-e", "PYTHONPATH=/app/.deps")
+	}
+	runArgs = append(runArgs, r.image, "python", "/app/script.py")
+
+	cmd := exec.CommandContext(ctx, "docker", runArgs...)
+	result, err := runStreamed(cmd, maxOutputBytes, onOutput)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			// exec.CommandContext only reaches `docker run` on the host, not
+			// the container it started, so kill it by name too.
+			_ = exec.Command("docker", "kill", handle.containerName).Run()
+			return result, err
+		}
+		return result, &RunnerError{Stage: "run", Err: err}
+	}
+	return result, nil
+}
+
+func (r *dockerRunner) Cleanup(h RunHandle) {
+	handle := h.(*dockerHandle)
+	os.RemoveAll(handle.tmpDir)
+}
+
+func (r *dockerRunner) Workdir(h RunHandle) string {
+	return h.(*dockerHandle).tmpDir
+}
+
+// localVenvHandle is the RunHandle produced by localVenvRunner.
+type localVenvHandle struct {
+	tmpDir     string
+	pythonBin  string
+	scriptPath string
+}
+
+// localVenvRunner executes code in an ephemeral virtualenv on the host,
+// giving users without Docker a way to use the tool. It prefers `uv venv`
+// when uv is on PATH since it installs dependencies dramatically faster, and
+// falls back to the stdlib `python -m venv` otherwise.
+type localVenvRunner struct{}
+
+func (r *localVenvRunner) Prepare(ctx context.Context, code string, modules []string) (RunHandle, error) {
+	tmpDir, err := os.MkdirTemp("", "python_venv")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+
+	venvDir := path.Join(tmpDir, "venv")
+	var createCmd *exec.Cmd
+	uvPath, uvErr := exec.LookPath("uv")
+	if uvErr == nil {
+		createCmd = exec.CommandContext(ctx, uvPath, "venv", "--quiet", venvDir)
+	} else {
+		createCmd = exec.CommandContext(ctx, "python3", "-m", "venv", venvDir)
+	}
+	if _, err := runStreamed(createCmd, defaultMaxOutputBytes, nil); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, &RunnerError{Stage: "install", Err: err}
+	}
+
+	pythonBin := filepath.Join(venvDir, "bin", "python")
+
+	if len(modules) > 0 {
+		var pipCmd *exec.Cmd
+		if uvErr == nil {
+			uvArgs := append([]string{"pip", "install", "--quiet", "--python", pythonBin}, modules...)
+			pipCmd = exec.CommandContext(ctx, uvPath, uvArgs...)
+		} else {
+			pipArgs := append([]string{"-m", "pip", "install", "--quiet"}, modules...)
+			pipCmd = exec.CommandContext(ctx, pythonBin, pipArgs...)
+		}
+		if _, err := runStreamed(pipCmd, defaultMaxOutputBytes, nil); err != nil {
+			os.RemoveAll(tmpDir)
+			return nil, &RunnerError{Stage: "install", Err: err}
+		}
+	}
+
+	scriptPath := path.Join(tmpDir, "script.py")
+	if err := os.WriteFile(scriptPath, []byte(code), 0644); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("failed to write script to file: %w", err)
+	}
+
+	return &localVenvHandle{tmpDir: tmpDir, pythonBin: pythonBin, scriptPath: scriptPath}, nil
+}
+
+func (r *localVenvRunner) Run(ctx context.Context, h RunHandle, maxOutputBytes int, onOutput OutputLineFunc) (RunResult, error) {
+	handle := h.(*localVenvHandle)
+
+	cmd := exec.CommandContext(ctx, handle.pythonBin, handle.scriptPath)
+	cmd.Dir = handle.tmpDir
+	result, err := runStreamed(cmd, maxOutputBytes, onOutput)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return result, err
+		}
+		return result, &RunnerError{Stage: "run", Err: err}
+	}
+	return result, nil
+}
+
+func (r *localVenvRunner) Cleanup(h RunHandle) {
+	handle := h.(*localVenvHandle)
+	os.RemoveAll(handle.tmpDir)
+}
+
+func (r *localVenvRunner) Workdir(h RunHandle) string {
+	return h.(*localVenvHandle).tmpDir
+}
+
+// runnerErrorMessage formats a Runner error for display, calling out which
+// stage failed when the error came back as a *RunnerError.
+func runnerErrorMessage(prefix string, err error) string {
+	if rerr, ok := err.(*RunnerError); ok {
+		return fmt.Sprintf("%s (%s step): %v", prefix, rerr.Stage, rerr.Err)
+	}
+	return fmt.Sprintf("%s: %v", prefix, err)
+}
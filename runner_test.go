@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunStreamedCapturesOutput(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "echo out-line; echo err-line 1>&2")
+
+	var lines []string
+	onOutput := func(stream, line string) {
+		lines = append(lines, stream+":"+line)
+	}
+
+	result, err := runStreamed(cmd, defaultMaxOutputBytes, onOutput)
+	if err != nil {
+		t.Fatalf("runStreamed returned unexpected error: %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Fatalf("ExitCode = %d, want 0", result.ExitCode)
+	}
+	if !strings.Contains(result.Stdout, "out-line") {
+		t.Fatalf("Stdout = %q, want it to contain %q", result.Stdout, "out-line")
+	}
+	if !strings.Contains(result.Stderr, "err-line") {
+		t.Fatalf("Stderr = %q, want it to contain %q", result.Stderr, "err-line")
+	}
+	if len(lines) != 2 {
+		t.Fatalf("onOutput called %d times, want 2 (got %v)", len(lines), lines)
+	}
+}
+
+func TestRunStreamedReportsNonZeroExit(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "exit 7")
+
+	result, err := runStreamed(cmd, defaultMaxOutputBytes, nil)
+	if err == nil {
+		t.Fatal("runStreamed should return an error for a non-zero exit code")
+	}
+	if result.ExitCode != 7 {
+		t.Fatalf("ExitCode = %d, want 7", result.ExitCode)
+	}
+}
+
+func TestRunStreamedHonorsContextDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sleep", "30")
+
+	done := make(chan struct{})
+	go func() {
+		runStreamed(cmd, defaultMaxOutputBytes, nil)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runStreamed did not return after its context deadline elapsed; sleep 30 was not killed")
+	}
+	if ctx.Err() != context.DeadlineExceeded {
+		t.Fatalf("ctx.Err() = %v, want context.DeadlineExceeded", ctx.Err())
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// sentinelPrefix marks the end of a snippet's output in the REPL stream.
+	// A random nonce is appended per-call so a script echoing the literal
+	// string can't fool the reader into ending the read early.
+	sentinelPrefix = "__MCP_DONE_"
+	// defaultIdleTimeout is how long a session container may sit unused
+	// before the GC reaps it.
+	defaultIdleTimeout = 10 * time.Minute
+)
+
+// replSession wraps a single long-running `python -u -i` container together
+// with the pipes used to feed it code and read its output. All access goes
+// through sessionManager, which owns the mutex below.
+type replSession struct {
+	mu            sync.Mutex
+	id            string
+	containerName string
+	cmd           *exec.Cmd
+	stdin         io.WriteCloser
+	stdoutReader  *bufio.Reader
+	stderr        limitedBuffer
+	lastUsed      time.Time
+}
+
+// sessionManager tracks the live replSessions for execute-python-session and
+// garbage collects ones that have been idle too long.
+type sessionManager struct {
+	mu             sync.Mutex
+	sessions       map[string]*replSession
+	idleTimeout    time.Duration
+	maxOutputBytes int
+}
+
+func newSessionManager(idleTimeout time.Duration, maxOutputBytes int) *sessionManager {
+	m := &sessionManager{
+		sessions:       make(map[string]*replSession),
+		idleTimeout:    idleTimeout,
+		maxOutputBytes: maxOutputBytes,
+	}
+	go m.gcLoop()
+	return m
+}
+
+// gcLoop periodically removes containers that have outlived idleTimeout so a
+// forgotten session doesn't leak a container forever.
+func (m *sessionManager) gcLoop() {
+	ticker := time.NewTicker(m.idleTimeout / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.mu.Lock()
+		for id, sess := range m.sessions {
+			sess.mu.Lock()
+			idle := time.Since(sess.lastUsed)
+			sess.mu.Unlock()
+			if idle > m.idleTimeout {
+				sess.close()
+				delete(m.sessions, id)
+			}
+		}
+		m.mu.Unlock()
+	}
+}
+
+// getOrCreate returns the session for sessionID, starting a new container for
+// it if this is the first time it's been seen.
+func (m *sessionManager) getOrCreate(sessionID string) (*replSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if sess, ok := m.sessions[sessionID]; ok {
+		return sess, nil
+	}
+
+	sess, err := startReplSession(sessionID, m.maxOutputBytes)
+	if err != nil {
+		return nil, err
+	}
+	m.sessions[sessionID] = sess
+	return sess, nil
+}
+
+// reset tears down and forgets the session for sessionID, if one exists.
+func (m *sessionManager) reset(sessionID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ok := m.sessions[sessionID]
+	if !ok {
+		return false
+	}
+	sess.close()
+	delete(m.sessions, sessionID)
+	return true
+}
+
+// startReplSession docker-runs an interactive Python interpreter, kept
+// attached (not detached), and wires up its stdio pipes.
+func startReplSession(sessionID string, maxOutputBytes int) (*replSession, error) {
+	containerName := fmt.Sprintf("mcp-python-session-%s", sessionID)
+
+	cmd := exec.Command(
+		"docker",
+		"run",
+		"-i",
+		"--rm",
+		"--name", containerName,
+		"mcr.microsoft.com/playwright/python:v1.49.1-noble",
+		"python", "-u", "-i",
+	)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout pipe: %w", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stderr pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start session container: %w", err)
+	}
+
+	sess := &replSession{
+		id:            sessionID,
+		containerName: containerName,
+		cmd:           cmd,
+		stdin:         stdin,
+		stdoutReader:  bufio.NewReader(stdout),
+		stderr:        limitedBuffer{max: maxOutputBytes},
+		lastUsed:      time.Now(),
+	}
+
+	// The REPL's stderr isn't sentinel-delimited, so it's pumped into a
+	// limitedBuffer in the background and drained after each Execute call.
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, err := stderr.Read(buf)
+			if n > 0 {
+				sess.mu.Lock()
+				sess.stderr.Write(buf[:n])
+				sess.mu.Unlock()
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return sess, nil
+}
+
+// execute runs code in the session's interpreter and returns the stdout
+// produced since the last call plus any stderr accumulated alongside it. It
+// blocks until the sentinel printed after code appears on stdout, the
+// context is cancelled, or the container exits.
+func (s *replSession) execute(ctx context.Context, code string) (stdout, stderr string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+
+	nonce := uuid.NewString()
+	sentinel := sentinelPrefix + nonce + "__"
+
+	// exec(compile(...)) rather than a bare exec so syntax errors and
+	// multi-line snippets behave the same as a plain script would.
+	wrapped := fmt.Sprintf(
+		"exec(compile(%q, '<mcp-session>', 'exec'))\nprint(%q)\n",
+		code,
+		sentinel,
+	)
+
+	readDone := make(chan error, 1)
+	var out string
+	go func() {
+		var lines []string
+		for {
+			line, readErr := s.stdoutReader.ReadString('\n')
+			if line != "" {
+				if trimmed := trimNewline(line); trimmed == sentinel {
+					break
+				}
+				lines = append(lines, line)
+			}
+			if readErr != nil {
+				readDone <- readErr
+				return
+			}
+		}
+		out = joinLines(lines)
+		readDone <- nil
+	}()
+
+	if _, writeErr := io.WriteString(s.stdin, wrapped); writeErr != nil {
+		return "", s.stderr.String(), fmt.Errorf("failed to write to session: %w", writeErr)
+	}
+
+	select {
+	case <-ctx.Done():
+		// The read goroutine above is blocked inside ReadString on
+		// s.stdoutReader, which isn't safe for concurrent use. Returning here
+		// while it's still running would let the next execute() on this
+		// session start a second ReadString racing with it, corrupting the
+		// stream for good. Killing the container forces that read to
+		// unblock (with an error), so we wait for it before releasing s.mu
+		// via the deferred unlock. The session is unusable after this; the
+		// caller needs reset-python-session to start a fresh one.
+		_ = exec.Command("docker", "kill", s.containerName).Run()
+		<-readDone
+		return "", s.stderr.String(), ctx.Err()
+	case readErr := <-readDone:
+		if readErr != nil {
+			return out, s.stderr.String(), fmt.Errorf("session stdout closed: %w", readErr)
+		}
+		return out, s.stderr.String(), nil
+	}
+}
+
+// close kills the session's container and releases its pipes.
+func (s *replSession) close() {
+	_ = exec.Command("docker", "rm", "-f", s.containerName).Run()
+	_ = s.stdin.Close()
+}
+
+func trimNewline(s string) string {
+	for len(s) > 0 && (s[len(s)-1] == '\n' || s[len(s)-1] == '\r') {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+func joinLines(lines []string) string {
+	out := ""
+	for _, l := range lines {
+		out += l
+	}
+	return out
+}
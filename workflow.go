@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/google/uuid"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// WorkflowStep is one step of an execute-python-workflow document. The
+// document's top-level `steps` list holds these, run in order.
+type WorkflowStep struct {
+	Code              string            `json:"code" yaml:"code"`
+	Modules           []string          `json:"modules,omitempty" yaml:"modules,omitempty"`
+	Before            string            `json:"before,omitempty" yaml:"before,omitempty"`
+	After             string            `json:"after,omitempty" yaml:"after,omitempty"`
+	ExpectStdoutRegex string            `json:"expect_stdout_regex,omitempty" yaml:"expect_stdout_regex,omitempty"`
+	Runner            string            `json:"runner,omitempty" yaml:"runner,omitempty"` // "host" or "container" (default)
+	Env               map[string]string `json:"env,omitempty" yaml:"env,omitempty"`
+	Workdir           string            `json:"workdir,omitempty" yaml:"workdir,omitempty"`
+	TimeoutSeconds    int               `json:"timeout_seconds,omitempty" yaml:"timeout_seconds,omitempty"`
+}
+
+// workflowDocument is the top-level shape of the execute-python-workflow
+// tool's `workflow` argument.
+type workflowDocument struct {
+	Steps []WorkflowStep `json:"steps" yaml:"steps"`
+}
+
+// StepResult is one step's outcome within execute-python-workflow.
+type StepResult struct {
+	Stdout         string `json:"stdout"`
+	Stderr         string `json:"stderr"`
+	ExitCode       int    `json:"exit_code"`
+	TimedOut       bool   `json:"timed_out"`
+	ExpectationMet *bool  `json:"expectation_met,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// stepRunner executes a single workflow step, honoring its env, workdir and
+// timeout. host and container steps need different plumbing (a direct
+// python3 invocation vs. a docker run), so each gets its own implementation.
+type stepRunner interface {
+	run(ctx context.Context, step WorkflowStep, root string, maxOutputBytes int) (StepResult, error)
+}
+
+func newStepRunner(name string, allowHostRunner bool) (stepRunner, error) {
+	switch name {
+	case "", "container":
+		return containerStepRunner{image: defaultPlaywrightImage}, nil
+	case "host":
+		if !allowHostRunner {
+			return nil, fmt.Errorf("runner: host is disabled on this server (start it with --allow-host-runner to enable)")
+		}
+		return hostStepRunner{}, nil
+	default:
+		return nil, fmt.Errorf("unknown step runner %q (want host or container)", name)
+	}
+}
+
+// workflowRoot is the server-owned temp directory every step's workdir is
+// confined to, so a caller-supplied workdir can never point a bind-mount or
+// host cwd somewhere else on disk.
+func workflowRoot() (dir string, cleanup func(), err error) {
+	tmpDir, err := os.MkdirTemp("", "python_workflow")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	return tmpDir, func() { os.RemoveAll(tmpDir) }, nil
+}
+
+// stepWorkdir resolves step.Workdir against root and creates it, or returns a
+// fresh subdirectory of root when step.Workdir is unset. safeJoin rejects any
+// Workdir whose `..` segments would escape root and nests an absolute one
+// under root, so a step can never bind-mount or cd outside the workflow's own
+// sandbox.
+func stepWorkdir(step WorkflowStep, root string) (dir string, err error) {
+	if step.Workdir == "" {
+		return os.MkdirTemp(root, "step")
+	}
+	dir, err = safeJoin(root, step.Workdir)
+	if err != nil {
+		return "", fmt.Errorf("workdir %q: %w", step.Workdir, err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("workdir %q: failed to create: %w", step.Workdir, err)
+	}
+	return dir, nil
+}
+
+// shellScript joins a step's before hook, module install, code and after
+// hook into the single `&&`-chained command both runners execute, so a
+// failure anywhere in the chain short-circuits the rest.
+func shellScript(step WorkflowStep, scriptPath string) string {
+	parts := []string{}
+	if step.Before != "" {
+		parts = append(parts, step.Before)
+	}
+	if len(step.Modules) > 0 {
+		parts = append(parts, fmt.Sprintf(
+			"python3 -m pip install --quiet %s", strings.Join(step.Modules, " "),
+		))
+	}
+	parts = append(parts, fmt.Sprintf("python3 %s", scriptPath))
+	if step.After != "" {
+		parts = append(parts, step.After)
+	}
+	return strings.Join(parts, " && ")
+}
+
+func envSlice(env map[string]string) []string {
+	out := make([]string, 0, len(env))
+	for k, v := range env {
+		out = append(out, fmt.Sprintf("%s=%s", k, v))
+	}
+	return out
+}
+
+// hostStepRunner runs a step's code directly against the local python3.
+type hostStepRunner struct{}
+
+func (hostStepRunner) run(
+	ctx context.Context,
+	step WorkflowStep,
+	root string,
+	maxOutputBytes int,
+) (StepResult, error) {
+	workDir, err := stepWorkdir(step, root)
+	if err != nil {
+		return StepResult{Error: err.Error()}, err
+	}
+
+	scriptPath := filepath.Join(workDir, "step.py")
+	if err := os.WriteFile(scriptPath, []byte(step.Code), 0644); err != nil {
+		werr := fmt.Errorf("failed to write step script: %w", err)
+		return StepResult{Error: werr.Error()}, werr
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", shellScript(step, scriptPath))
+	cmd.Dir = workDir
+	cmd.Env = append(os.Environ(), envSlice(step.Env)...)
+
+	return stepResultFromCmd(ctx, cmd, maxOutputBytes, "")
+}
+
+// containerStepRunner runs a step's code inside the playwright docker image,
+// mounting workDir so the script and any before/after hooks share a
+// filesystem the same way the host runner's hooks do.
+type containerStepRunner struct {
+	image string
+}
+
+func (r containerStepRunner) run(
+	ctx context.Context,
+	step WorkflowStep,
+	root string,
+	maxOutputBytes int,
+) (StepResult, error) {
+	workDir, err := stepWorkdir(step, root)
+	if err != nil {
+		return StepResult{Error: err.Error()}, err
+	}
+
+	scriptPath := filepath.Join(workDir, "step.py")
+	if err := os.WriteFile(scriptPath, []byte(step.Code), 0644); err != nil {
+		werr := fmt.Errorf("failed to write step script: %w", err)
+		return StepResult{Error: werr.Error()}, werr
+	}
+
+	containerName := fmt.Sprintf("mcp-python-step-%s", uuid.NewString())
+	dockerArgs := []string{"run", "--rm", "--name", containerName, "-v", fmt.Sprintf("%s:/app", workDir), "-w", "/app"}
+	for _, kv := range envSlice(step.Env) {
+		dockerArgs = append(dockerArgs, "-e", kv)
+	}
+	dockerArgs = append(dockerArgs, r.image, "sh", "-c", shellScript(step, "/app/step.py"))
+
+	cmd := exec.CommandContext(ctx, "docker", dockerArgs...)
+	return stepResultFromCmd(ctx, cmd, maxOutputBytes, containerName)
+}
+
+// stepResultFromCmd runs cmd and translates runStreamed's RunResult into a
+// StepResult, folding in whether the step's deadline was the cause of
+// failure. containerName is the name of the docker container cmd started, if
+// any ("" for the host runner); on timeout it's killed directly, since
+// exec.CommandContext only kills the local `docker run` client, not the
+// container it started.
+func stepResultFromCmd(ctx context.Context, cmd *exec.Cmd, maxOutputBytes int, containerName string) (StepResult, error) {
+	result, err := runStreamed(cmd, maxOutputBytes, nil)
+	timedOut := ctx.Err() == context.DeadlineExceeded
+	if timedOut && containerName != "" {
+		_ = exec.Command("docker", "kill", containerName).Run()
+	}
+
+	step := StepResult{
+		Stdout:   result.Stdout,
+		Stderr:   result.Stderr,
+		ExitCode: result.ExitCode,
+		TimedOut: timedOut,
+	}
+	if err != nil && !timedOut {
+		step.Error = err.Error()
+		return step, err
+	}
+	return step, nil
+}
+
+// handlePythonWorkflow returns the execute-python-workflow tool handler.
+// allowHostRunner gates whether a step may request runner: host at all, since
+// that backend executes caller-supplied shell directly on this machine with
+// its full environment.
+func handlePythonWorkflow(maxTimeoutSeconds int, maxOutputBytes int, allowHostRunner bool) server.ToolHandlerFunc {
+	return func(
+		ctx context.Context,
+		request mcp.CallToolRequest,
+	) (*mcp.CallToolResult, error) {
+		doc, ok := request.Params.Arguments["workflow"].(string)
+		if !ok || doc == "" {
+			return mcp.NewToolResultError("Missing or invalid workflow argument"), nil
+		}
+
+		// yaml.Unmarshal also accepts plain JSON, since JSON is valid YAML,
+		// so callers can send either without us needing to sniff the format.
+		var wf workflowDocument
+		if err := yaml.Unmarshal([]byte(doc), &wf); err != nil {
+			return mcp.NewToolResultError(
+				fmt.Sprintf("Failed to parse workflow: %v", err),
+			), nil
+		}
+		if len(wf.Steps) == 0 {
+			return mcp.NewToolResultError("workflow must contain at least one step"), nil
+		}
+
+		root, cleanupRoot, err := workflowRoot()
+		if err != nil {
+			return mcp.NewToolResultError(err.Error()), nil
+		}
+		defer cleanupRoot()
+
+		results := make([]StepResult, 0, len(wf.Steps))
+		for _, step := range wf.Steps {
+			runner, err := newStepRunner(step.Runner, allowHostRunner)
+			if err != nil {
+				results = append(results, StepResult{Error: err.Error()})
+				break
+			}
+
+			timeoutSeconds := defaultTimeoutSeconds
+			if step.TimeoutSeconds > 0 {
+				timeoutSeconds = step.TimeoutSeconds
+			}
+			if timeoutSeconds > maxTimeoutSeconds {
+				timeoutSeconds = maxTimeoutSeconds
+			}
+
+			stepCtx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSeconds)*time.Second)
+			result, runErr := runner.run(stepCtx, step, root, maxOutputBytes)
+			cancel()
+
+			if step.ExpectStdoutRegex != "" {
+				applyExpectation(&result, step.ExpectStdoutRegex)
+			}
+
+			results = append(results, result)
+
+			if runErr != nil || result.Error != "" || result.TimedOut {
+				break
+			}
+		}
+
+		payload, err := json.Marshal(struct {
+			Steps []StepResult `json:"steps"`
+		}{Steps: results})
+		if err != nil {
+			return mcp.NewToolResultError(
+				fmt.Sprintf("Failed to encode result: %v", err),
+			), nil
+		}
+
+		return mcp.NewToolResultText(string(payload)), nil
+	}
+}
+
+// applyExpectation checks a step's stdout against its expect_stdout_regex,
+// recording the outcome on result in place.
+func applyExpectation(result *StepResult, pattern string) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		result.Error = fmt.Sprintf("invalid expect_stdout_regex: %v", err)
+		return
+	}
+	met := re.MatchString(result.Stdout)
+	result.ExpectationMet = &met
+	if !met && result.Error == "" {
+		result.Error = "expect_stdout_regex did not match stdout"
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyExpectationMatch(t *testing.T) {
+	result := &StepResult{Stdout: "status: ok\n"}
+	applyExpectation(result, "status: ok")
+
+	if result.ExpectationMet == nil || !*result.ExpectationMet {
+		t.Fatalf("ExpectationMet = %v, want true", result.ExpectationMet)
+	}
+	if result.Error != "" {
+		t.Fatalf("Error = %q, want empty", result.Error)
+	}
+}
+
+func TestApplyExpectationNoMatch(t *testing.T) {
+	result := &StepResult{Stdout: "status: fail\n"}
+	applyExpectation(result, "status: ok")
+
+	if result.ExpectationMet == nil || *result.ExpectationMet {
+		t.Fatalf("ExpectationMet = %v, want false", result.ExpectationMet)
+	}
+	if result.Error == "" {
+		t.Fatal("Error should be set when expect_stdout_regex does not match")
+	}
+}
+
+func TestApplyExpectationInvalidRegex(t *testing.T) {
+	result := &StepResult{Stdout: "anything"}
+	applyExpectation(result, "[")
+
+	if result.ExpectationMet != nil {
+		t.Fatalf("ExpectationMet = %v, want nil for an invalid pattern", result.ExpectationMet)
+	}
+	if result.Error == "" {
+		t.Fatal("Error should be set when expect_stdout_regex fails to compile")
+	}
+}
+
+func TestNewStepRunnerGatesHostBehindFlag(t *testing.T) {
+	if _, err := newStepRunner("host", false); err == nil {
+		t.Fatal(`newStepRunner("host", false) should be rejected when host runner is disabled`)
+	}
+	if _, err := newStepRunner("host", true); err != nil {
+		t.Fatalf(`newStepRunner("host", true) returned unexpected error: %v`, err)
+	}
+	if _, err := newStepRunner("bogus", true); err == nil {
+		t.Fatal(`newStepRunner("bogus", true) should reject an unknown runner name`)
+	}
+}
+
+func TestStepWorkdirRejectsEscape(t *testing.T) {
+	root := t.TempDir()
+
+	if _, err := stepWorkdir(WorkflowStep{Workdir: "../escape"}, root); err == nil {
+		t.Fatal("stepWorkdir should reject a workdir that escapes root")
+	}
+}
+
+func TestStepWorkdirDefaultsUnderRoot(t *testing.T) {
+	root := t.TempDir()
+
+	dir, err := stepWorkdir(WorkflowStep{}, root)
+	if err != nil {
+		t.Fatalf("stepWorkdir returned unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(dir, root) {
+		t.Fatalf("stepWorkdir returned %q, want a path under root %q", dir, root)
+	}
+}